@@ -0,0 +1,184 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kiss-o'-Death reference identifiers a client must honor by backing off.
+// RFC 5905 reserves all-caps ASCII codes in stratum-0 packets for this
+// purpose; RATE, DENY and RSTR all mean "stop querying this server".
+const (
+	KissCodeRate = "RATE"
+	KissCodeDeny = "DENY"
+	KissCodeRstr = "RSTR"
+)
+
+// maxKoDBackoff caps how long RateLimitingHandler will back off a server
+// after repeated Kiss-o'-Death responses.
+const maxKoDBackoff = time.Hour
+
+// KissOfDeathError is returned by a Handler wrapped with
+// RateLimitingHandler, either when a server's response carries a
+// Kiss-o'-Death code or when a prior one is still being honored. Callers
+// can type-assert the error returned by Query to distinguish this case
+// from a generic network failure.
+type KissOfDeathError struct {
+	Addr string
+	Code string
+}
+
+func (e *KissOfDeathError) Error() string {
+	return fmt.Sprintf("ntp: %s sent kiss-o'-death code %q", e.Addr, e.Code)
+}
+
+// KoDStore records, per server address, how long a RateLimitingHandler
+// must back off after receiving a Kiss-o'-Death response. Implementations
+// may be in-memory (see NewMemoryKoDStore) or persistent, so a
+// long-running daemon can remember the back-off across restarts.
+type KoDStore interface {
+	// Backoff reports the time until which addr should not be queried,
+	// the delay that produced that deadline (so a repeat KoD can double
+	// it), and whether a back-off is currently recorded for addr at all.
+	Backoff(addr string) (until time.Time, delay time.Duration, ok bool)
+	// SetBackoff records that addr must not be queried again until
+	// until, that the back-off was computed as delay, and that the
+	// server's last response carried code.
+	SetBackoff(addr string, until time.Time, delay time.Duration, code string)
+}
+
+type kodEntry struct {
+	until time.Time
+	delay time.Duration
+}
+
+type memoryKoDStore struct {
+	mu      sync.Mutex
+	entries map[string]kodEntry
+}
+
+// NewMemoryKoDStore returns a KoDStore backed by an in-memory map. It does
+// not survive process restarts.
+func NewMemoryKoDStore() KoDStore {
+	return &memoryKoDStore{entries: make(map[string]kodEntry)}
+}
+
+func (s *memoryKoDStore) Backoff(addr string) (time.Time, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[addr]
+	return e.until, e.delay, ok
+}
+
+func (s *memoryKoDStore) SetBackoff(addr string, until time.Time, delay time.Duration, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[addr] = kodEntry{until: until, delay: delay}
+}
+
+// RateLimitingHandler wraps inner, the Handler for the server at addr, so
+// that it honors Kiss-o'-Death responses as required by RFC 5905: once the
+// server returns a stratum-0 packet carrying a RATE, DENY or RSTR
+// reference identifier, addr is not queried again until the back-off
+// recorded in store expires. The back-off starts at the response's Poll
+// interval and doubles on each repeat KoD, up to maxKoDBackoff.
+//
+// The leading addr parameter is a deliberate deviation from a bare
+// RateLimitingHandler(inner, store) shape: inner is just a
+// func([]byte) ([]byte, error) closure with no way to recover which
+// server it talks to, but store needs a stable key to record the
+// back-off against, so the caller must supply one explicitly.
+func RateLimitingHandler(addr string, inner Handler, store KoDStore) Handler {
+	return func(data []byte) ([]byte, error) {
+		until, prevDelay, hadBackoff := store.Backoff(addr)
+		if hadBackoff && time.Now().Before(until) {
+			return nil, &KissOfDeathError{Addr: addr, Code: "backoff in effect"}
+		}
+
+		resp, err := inner(data)
+		if err != nil {
+			return resp, err
+		}
+
+		m, code, ok := kissCode(resp)
+		if !ok {
+			return resp, nil
+		}
+
+		delay := pollInterval(m)
+		if hadBackoff && 2*prevDelay > delay {
+			delay = 2 * prevDelay
+		}
+		if delay > maxKoDBackoff {
+			delay = maxKoDBackoff
+		}
+		store.SetBackoff(addr, time.Now().Add(delay), delay, code)
+
+		return resp, &KissOfDeathError{Addr: addr, Code: code}
+	}
+}
+
+// KissCode returns the Kiss-o'-Death reference identifier carried by
+// resp, interpreted as a 4-byte ASCII code, so that a plain
+// Query/QueryWithOptions caller can read it without going through
+// RateLimitingHandler. ok is false unless resp.Stratum is 0 and the
+// reference identifier is one of RATE, DENY or RSTR.
+func KissCode(resp *Response) (code string, ok bool) {
+	if resp == nil {
+		return "", false
+	}
+	return kissCodeFromIdentifiers(resp.Stratum, resp.ReferenceID)
+}
+
+// kissCode decodes raw as an NTP message and returns it together with its
+// Kiss-o'-Death code; ok is true only when the stratum is 0 and the
+// reference identifier is one of RATE, DENY or RSTR.
+// It underlies RateLimitingHandler, which only has the raw response
+// bytes to work with, not a parsed *Response.
+func kissCode(raw []byte) (*msg, string, bool) {
+	var m msg
+	if err := binary.Read(bytes.NewReader(raw), binary.BigEndian, &m); err != nil {
+		return nil, "", false
+	}
+	code, ok := kissCodeFromIdentifiers(m.Stratum, m.ReferenceID)
+	return &m, code, ok
+}
+
+// kissCodeFromIdentifiers implements the shared stratum/reference-ID to
+// Kiss-o'-Death-code decoding used by both KissCode and kissCode.
+func kissCodeFromIdentifiers(stratum uint8, referenceID uint32) (string, bool) {
+	if stratum != 0 {
+		return "", false
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, referenceID)
+	switch code := string(b); code {
+	case KissCodeRate, KissCodeDeny, KissCodeRstr:
+		return code, true
+	default:
+		return "", false
+	}
+}
+
+// maxKoDPollExponent bounds the poll exponent read from a (possibly
+// forged) KoD packet before it is used to compute a bit shift, so a
+// malicious or buggy server cannot overflow the resulting duration.
+const maxKoDPollExponent = 32
+
+// pollInterval returns the poll interval advertised by m, in seconds, or
+// one minute if m did not advertise a usable value. The exponent is
+// clamped to maxKoDPollExponent: RFC 5905 allows Poll up to 127, which
+// would overflow a time.Duration shift and defeat the back-off entirely.
+func pollInterval(m *msg) time.Duration {
+	if m == nil || m.Poll <= 0 {
+		return time.Minute
+	}
+	poll := m.Poll
+	if poll > maxKoDPollExponent {
+		poll = maxKoDPollExponent
+	}
+	return time.Duration(1) << uint(poll) * time.Second
+}
@@ -0,0 +1,97 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// rateResponse builds a raw 48-byte NTP response carrying a stratum-0
+// RATE Kiss-o'-Death code with the given poll exponent.
+func rateResponse(poll int8) []byte {
+	m := msg{Stratum: 0, Poll: poll, ReferenceID: binary.BigEndian.Uint32([]byte(KissCodeRate))}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, m)
+	return buf.Bytes()
+}
+
+func TestRateLimitingHandlerDoublesBackoff(t *testing.T) {
+	store := NewMemoryKoDStore()
+	calls := 0
+	inner := func(data []byte) ([]byte, error) {
+		calls++
+		return rateResponse(1), nil // pollInterval == 2s
+	}
+	h := RateLimitingHandler("server:123", inner, store)
+
+	if _, err := h(nil); err == nil {
+		t.Fatal("expected a KissOfDeathError on the first call")
+	}
+	_, firstDelay, ok := store.Backoff("server:123")
+	if !ok {
+		t.Fatal("expected a backoff to be recorded")
+	}
+	if firstDelay != 2*time.Second {
+		t.Fatalf("firstDelay = %s, want 2s", firstDelay)
+	}
+
+	// Force the stored deadline into the past so the wrapper dispatches
+	// to inner again instead of short-circuiting on the active backoff.
+	store.SetBackoff("server:123", time.Now().Add(-time.Second), firstDelay, KissCodeRate)
+
+	if _, err := h(nil); err == nil {
+		t.Fatal("expected a KissOfDeathError on the repeat call")
+	}
+	_, secondDelay, _ := store.Backoff("server:123")
+	if secondDelay != 2*firstDelay {
+		t.Fatalf("secondDelay = %s, want double of firstDelay (%s)", secondDelay, 2*firstDelay)
+	}
+	if calls != 2 {
+		t.Fatalf("inner called %d times, want 2", calls)
+	}
+}
+
+func TestRateLimitingHandlerHonorsActiveBackoff(t *testing.T) {
+	store := NewMemoryKoDStore()
+	calls := 0
+	inner := func(data []byte) ([]byte, error) {
+		calls++
+		return rateResponse(1), nil
+	}
+	h := RateLimitingHandler("server:123", inner, store)
+
+	if _, err := h(nil); err == nil {
+		t.Fatal("expected a KissOfDeathError on the first call")
+	}
+	if _, err := h(nil); err == nil {
+		t.Fatal("expected the active backoff to be honored without calling inner")
+	}
+	if calls != 1 {
+		t.Fatalf("inner called %d times, want 1 (second call should have been short-circuited)", calls)
+	}
+}
+
+func TestKissCodeExposedOnResponse(t *testing.T) {
+	resp := &Response{Stratum: 0, ReferenceID: binary.BigEndian.Uint32([]byte(KissCodeDeny))}
+	code, ok := KissCode(resp)
+	if !ok || code != KissCodeDeny {
+		t.Fatalf("KissCode(resp) = %q, %v, want %q, true", code, ok, KissCodeDeny)
+	}
+
+	notKoD := &Response{Stratum: 1, ReferenceID: binary.BigEndian.Uint32([]byte(KissCodeDeny))}
+	if _, ok := KissCode(notKoD); ok {
+		t.Fatal("KissCode should not report a code for a non-zero stratum response")
+	}
+}
+
+func TestPollIntervalClampsLargeExponent(t *testing.T) {
+	// Poll=127 is the max a (possibly forged) KoD packet can advertise;
+	// left unclamped, 1<<127 overflows time.Duration and can produce a
+	// zero or negative delay, defeating the back-off entirely.
+	m := &msg{Poll: 127}
+	d := pollInterval(m)
+	if d <= 0 {
+		t.Fatalf("pollInterval(Poll=127) = %s, want a positive duration", d)
+	}
+}
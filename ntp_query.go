@@ -97,6 +97,9 @@ func getTime(rawFunc func(data []byte) ([]byte, error), opt QueryOptions) (*msg,
 	rawWriter.Flush()
 
 	recBuf, err := rawFunc(rawData.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
 	recRead := bytes.NewReader(recBuf)
 	err = binary.Read(recRead, binary.BigEndian, recvMsg)
 	if err != nil {
@@ -0,0 +1,140 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestNTSSessionRoundTrip exercises secureRequest/verifyResponse against a
+// hand-built response whose Authenticator covers the NTP header plus the
+// echoed Unique Identifier field, exactly as an RFC 8915 compliant server
+// would compute it. This is the case that previously failed because
+// verifyResponse authenticated only the 48-byte header.
+func TestNTSSessionRoundTrip(t *testing.T) {
+	sess := &ntsSession{
+		cookies: [][]byte{bytes.Repeat([]byte{0x01}, 8)},
+		c2sKey:  bytes.Repeat([]byte{0x02}, 16),
+		s2cKey:  bytes.Repeat([]byte{0x03}, 16),
+		opt:     NTSOptions{MinCookies: 1, NewAEAD: defaultNewAEAD},
+	}
+
+	req := bytes.Repeat([]byte{0xAA}, 48)
+	out, uid, err := sess.secureRequest(req)
+	if err != nil {
+		t.Fatalf("secureRequest: %v", err)
+	}
+
+	header := bytes.Repeat([]byte{0xBB}, 48)
+
+	var uidField bytes.Buffer
+	writeExtField(&uidField, extUniqueIdentifier, uid)
+
+	aad := append(append([]byte(nil), header...), uidField.Bytes()...)
+
+	aead, err := sess.opt.NewAEAD(sess.s2cKey)
+	if err != nil {
+		t.Fatalf("NewAEAD: %v", err)
+	}
+	nonce := bytes.Repeat([]byte{0x04}, aead.NonceSize())
+	ciphertext := aead.Seal(nil, nonce, nil, aad)
+
+	var authBody bytes.Buffer
+	binary.Write(&authBody, binary.BigEndian, uint16(len(nonce)))
+	binary.Write(&authBody, binary.BigEndian, uint16(len(ciphertext)))
+	authBody.Write(nonce)
+	authBody.Write(ciphertext)
+
+	var resp bytes.Buffer
+	resp.Write(header)
+	resp.Write(uidField.Bytes())
+	writeExtField(&resp, extNTSAuthenticator, authBody.Bytes())
+
+	got, err := sess.verifyResponse(resp.Bytes(), uid)
+	if err != nil {
+		t.Fatalf("verifyResponse: %v", err)
+	}
+	if !bytes.Equal(got, header) {
+		t.Fatalf("verifyResponse returned %x, want %x", got, header)
+	}
+
+	_ = out // the raw request bytes aren't needed beyond generating uid
+}
+
+// TestSecureRequestEncryptsCookiePlaceholder confirms that a requested
+// Cookie Placeholder (RFC 8915 section 5.7) is carried inside the
+// Authenticator's encrypted extensions, not as a separate cleartext
+// extension field the way the Unique Identifier and Cookie are.
+func TestSecureRequestEncryptsCookiePlaceholder(t *testing.T) {
+	sess := &ntsSession{
+		cookies: [][]byte{bytes.Repeat([]byte{0x01}, 8)},
+		c2sKey:  bytes.Repeat([]byte{0x02}, 16),
+		s2cKey:  bytes.Repeat([]byte{0x03}, 16),
+		opt:     NTSOptions{MinCookies: 1, NewAEAD: defaultNewAEAD},
+	}
+
+	req := bytes.Repeat([]byte{0xAA}, 48)
+	out, _, err := sess.secureRequest(req)
+	if err != nil {
+		t.Fatalf("secureRequest: %v", err)
+	}
+
+	fields, err := parseExtFields(out[48:])
+	if err != nil {
+		t.Fatalf("parseExtFields: %v", err)
+	}
+	for _, f := range fields {
+		if f.Type == extNTSCookiePlaceholder {
+			t.Fatal("Cookie Placeholder appeared in cleartext; it must only be recoverable after AEAD decryption")
+		}
+	}
+
+	var authField extField
+	var haveAuth bool
+	for _, f := range fields {
+		if f.Type == extNTSAuthenticator {
+			authField, haveAuth = f, true
+		}
+	}
+	if !haveAuth {
+		t.Fatal("secureRequest output has no Authenticator field")
+	}
+	authBody := authField.Raw[4:]
+	nonceLen := binary.BigEndian.Uint16(authBody[0:2])
+	ctLen := binary.BigEndian.Uint16(authBody[2:4])
+	authBody = authBody[4:]
+	nonce := authBody[:nonceLen]
+	ciphertext := authBody[nonceLen : nonceLen+ctLen]
+
+	var cleartext bytes.Buffer
+	for _, f := range fields {
+		if f.Type == extNTSAuthenticator {
+			break
+		}
+		cleartext.Write(f.Raw)
+	}
+	aad := append(append([]byte(nil), req...), cleartext.Bytes()...)
+
+	aead, err := sess.opt.NewAEAD(sess.c2sKey)
+	if err != nil {
+		t.Fatalf("NewAEAD: %v", err)
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	encryptedFields, err := parseExtFields(plain)
+	if err != nil {
+		t.Fatalf("parseExtFields(plain): %v", err)
+	}
+	var foundPlaceholder bool
+	for _, f := range encryptedFields {
+		if f.Type == extNTSCookiePlaceholder {
+			foundPlaceholder = true
+		}
+	}
+	if !foundPlaceholder {
+		t.Fatal("Cookie Placeholder not found among the encrypted extensions")
+	}
+}
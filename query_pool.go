@@ -0,0 +1,141 @@
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handler is the function signature returned by MakeDefaultHandler and
+// accepted by Query, QueryWithOptions and the multi-server helpers below.
+// It performs a single raw NTP request/response exchange with a remote
+// server.
+type Handler func(data []byte) ([]byte, error)
+
+// AggregationStrategy selects how the ClockOffset values returned by
+// several servers are combined into a single authoritative offset.
+type AggregationStrategy int
+
+const (
+	// AggregateMedian sorts the surviving offsets and picks the middle
+	// value, averaging the two middle values when there is an even
+	// number of them. It is the default strategy because a single
+	// mis-set server cannot skew it the way a mean can.
+	AggregateMedian AggregationStrategy = iota
+	// AggregateMean averages all surviving offsets.
+	AggregateMean
+	// AggregateBestRTT uses the offset reported by the server with the
+	// lowest round-trip time.
+	AggregateBestRTT
+)
+
+// PoolResult records the outcome of querying a single server as part of a
+// QueryAggregate call, so callers can log or inspect the individual
+// servers that made up an aggregated result.
+type PoolResult struct {
+	Handler  Handler
+	Response *Response
+	Err      error
+	RTT      time.Duration
+}
+
+// QueryAggregate queries every handler in handlers concurrently, discards
+// any response that fails Validate, and combines the surviving
+// ClockOffset values into a single authoritative Response using strategy.
+// The call fails once more than maxAllowedFailures handlers have failed,
+// or when no handler returned a usable response at all; the outcome of every handler, successful or not, is always
+// returned in the []PoolResult slice so callers can inspect individual
+// servers. This is the pattern most multi-server callers (e.g. querying
+// 0/1/2/3.pool.ntp.org with one allowed failure) end up reimplementing on
+// top of Query.
+func QueryAggregate(handlers []Handler, maxAllowedFailures int, strategy AggregationStrategy) (*Response, []PoolResult, error) {
+	if len(handlers) == 0 {
+		return nil, nil, errors.New("ntp: no handlers supplied")
+	}
+
+	results := make([]PoolResult, len(handlers))
+	var wg sync.WaitGroup
+	wg.Add(len(handlers))
+	for i, h := range handlers {
+		go func(i int, h Handler) {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := Query(h)
+			rtt := time.Since(start)
+			if err == nil {
+				err = resp.Validate()
+			}
+			results[i] = PoolResult{Handler: h, Response: resp, Err: err, RTT: rtt}
+		}(i, h)
+	}
+	wg.Wait()
+
+	var offsets []time.Duration
+	failures := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failures++
+			continue
+		}
+		offsets = append(offsets, r.Response.ClockOffset)
+	}
+	if failures > maxAllowedFailures {
+		return nil, results, fmt.Errorf("ntp: %d of %d servers failed, exceeding the allowed %d", failures, len(handlers), maxAllowedFailures)
+	}
+	if len(offsets) == 0 {
+		return nil, results, errors.New("ntp: no server returned a usable response")
+	}
+
+	best := bestRTTResult(results)
+
+	var offset time.Duration
+	switch strategy {
+	case AggregateMean:
+		offset = meanOffset(offsets)
+	case AggregateBestRTT:
+		offset = best.Response.ClockOffset
+	default:
+		offset = medianOffset(offsets)
+	}
+
+	agg := *best.Response
+	agg.ClockOffset = offset
+	return &agg, results, nil
+}
+
+// medianOffset returns the median of offsets, averaging the two middle
+// values when there is an even number of them.
+func medianOffset(offsets []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// meanOffset returns the arithmetic mean of offsets.
+func meanOffset(offsets []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, o := range offsets {
+		sum += o
+	}
+	return sum / time.Duration(len(offsets))
+}
+
+// bestRTTResult returns the successful PoolResult with the lowest RTT.
+func bestRTTResult(results []PoolResult) *PoolResult {
+	var best *PoolResult
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		if best == nil || results[i].RTT < best.RTT {
+			best = &results[i]
+		}
+	}
+	return best
+}
@@ -0,0 +1,130 @@
+package ntp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSanityCheckMinInterval is the smallest gap a SanityChecker will
+// leave between two network checks when Check is called opportunistically.
+const DefaultSanityCheckMinInterval = time.Minute
+
+// DriftError is returned by SanityCheck when the measured drift exceeds
+// the requested threshold. Its distinct type lets callers, including
+// SanityChecker.Check, tell "the clock has drifted" apart from other
+// failures such as no server responding.
+type DriftError struct {
+	Drift     time.Duration
+	Threshold time.Duration
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("ntp: clock drift of %s exceeds threshold of %s", e.Drift, e.Threshold)
+}
+
+// SanityCheck queries handlers, drops the highest and lowest offset as
+// outliers when at least three servers responded, and returns the mean of
+// the rest as drift. err is nil ("clock ok") when the absolute drift is
+// within threshold; it is a *DriftError describing the amount of drift
+// when threshold is exceeded, and any other error when the check itself
+// couldn't be completed (e.g. no handlers supplied or no server
+// responded). This is the pattern a node uses to periodically confirm
+// that its wall clock hasn't drifted enough to invalidate protocol-level
+// timestamps.
+func SanityCheck(handlers []Handler, threshold time.Duration) (drift time.Duration, err error) {
+	if len(handlers) == 0 {
+		return 0, errors.New("ntp: no handlers supplied")
+	}
+
+	var offsets []time.Duration
+	for _, h := range handlers {
+		resp, err := Query(h)
+		if err != nil || resp.Validate() != nil {
+			continue
+		}
+		offsets = append(offsets, resp.ClockOffset)
+	}
+	if len(offsets) == 0 {
+		return 0, errors.New("ntp: no server returned a usable response")
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	if len(offsets) >= 3 {
+		offsets = offsets[1 : len(offsets)-1]
+	}
+
+	var sum time.Duration
+	for _, o := range offsets {
+		sum += o
+	}
+	drift = sum / time.Duration(len(offsets))
+
+	abs := drift
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs <= threshold {
+		return drift, nil
+	}
+	return drift, &DriftError{Drift: drift, Threshold: threshold}
+}
+
+// SanityChecker runs SanityCheck opportunistically, no more often than
+// MinInterval, and reports drift that exceeds Threshold via OnDrift. It is
+// safe to call Check from any code path without risking abuse of the
+// configured servers.
+type SanityChecker struct {
+	Handlers    []Handler
+	Threshold   time.Duration
+	MinInterval time.Duration
+	OnDrift     func(drift time.Duration)
+
+	mu        sync.Mutex
+	lastCheck time.Time
+}
+
+// Check runs SanityCheck if at least MinInterval has passed since the
+// previous network check, invoking OnDrift when the clock has drifted
+// beyond Threshold. If less than MinInterval has passed, Check returns
+// immediately without touching the network.
+func (s *SanityChecker) Check() (drift time.Duration, err error) {
+	minInterval := s.MinInterval
+	if minInterval == 0 {
+		minInterval = DefaultSanityCheckMinInterval
+	}
+
+	s.mu.Lock()
+	if !s.lastCheck.IsZero() && time.Since(s.lastCheck) < minInterval {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	s.lastCheck = time.Now()
+	s.mu.Unlock()
+
+	drift, err = SanityCheck(s.Handlers, s.Threshold)
+	var de *DriftError
+	if errors.As(err, &de) && s.OnDrift != nil {
+		s.OnDrift(drift)
+	}
+	return drift, err
+}
+
+// SanityCheckLoop calls checker.Check every interval until ctx is
+// canceled. It is intended for daemons that want SanityChecker's OnDrift
+// callback invoked on a fixed schedule rather than opportunistically.
+func SanityCheckLoop(ctx context.Context, checker *SanityChecker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checker.Check()
+		}
+	}
+}
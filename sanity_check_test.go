@@ -0,0 +1,71 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeOffsetHandler returns a Handler that answers any request as if it
+// came from a server whose clock is offset by d from the local clock,
+// without touching the network.
+func fakeOffsetHandler(d time.Duration) Handler {
+	return func(data []byte) ([]byte, error) {
+		var req msg
+		if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &req); err != nil {
+			return nil, err
+		}
+		resp := new(msg)
+		resp.setMode(server)
+		resp.setVersion(4)
+		resp.Stratum = 1
+		resp.OriginTime = req.TransmitTime
+		now := time.Now().Add(d)
+		resp.ReceiveTime = toNtpTime(now)
+		resp.TransmitTime = toNtpTime(now)
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, resp)
+		return buf.Bytes(), nil
+	}
+}
+
+func TestSanityCheckReportsDriftError(t *testing.T) {
+	handlers := []Handler{
+		fakeOffsetHandler(time.Hour),
+		fakeOffsetHandler(time.Hour),
+		fakeOffsetHandler(time.Hour),
+	}
+	_, err := SanityCheck(handlers, time.Minute)
+	var de *DriftError
+	if !errors.As(err, &de) {
+		t.Fatalf("SanityCheck error = %v, want a *DriftError", err)
+	}
+}
+
+func TestSanityCheckOKWithinThreshold(t *testing.T) {
+	handlers := []Handler{
+		fakeOffsetHandler(0),
+		fakeOffsetHandler(0),
+		fakeOffsetHandler(0),
+	}
+	if _, err := SanityCheck(handlers, time.Minute); err != nil {
+		t.Fatalf("SanityCheck error = %v, want nil", err)
+	}
+}
+
+func TestSanityCheckerOnDriftOnlyFiresOnDrift(t *testing.T) {
+	calls := 0
+	checker := &SanityChecker{
+		Handlers:  nil, // forces the "no handlers supplied" error path
+		Threshold: time.Minute,
+		OnDrift:   func(time.Duration) { calls++ },
+	}
+	if _, err := checker.Check(); err == nil {
+		t.Fatal("expected an error when no handlers are configured")
+	}
+	if calls != 0 {
+		t.Fatalf("OnDrift called %d times for a non-drift error, want 0", calls)
+	}
+}
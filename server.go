@@ -0,0 +1,124 @@
+package ntp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Request is the decoded form of a client query received by Serve.
+type Request struct {
+	// Addr is the address the request was received from.
+	Addr net.Addr
+	// TransmitTime is the client's TransmitTime field decoded as a time.
+	// Query fills that field with random bits rather than its clock, so
+	// it is not necessarily the time at which the request was sent.
+	TransmitTime time.Time
+}
+
+// Server implements a minimal NTP/SNTP server on top of the package's
+// existing wire codec, answering client requests entirely in-process.
+// This lets test suites exercise Query/QueryWithOptions without relying
+// on an external NTP server.
+type Server struct {
+	// Stratum is reported to clients in every response.
+	Stratum uint8
+	// RefID identifies the reference clock or upstream server this
+	// Server derives its time from, e.g. [4]byte{'L', 'O', 'C', 'L'}.
+	RefID [4]byte
+	// ReferenceClock returns the time this Server considers
+	// authoritative. It defaults to time.Now when left nil.
+	ReferenceClock func() time.Time
+	// Handler, when set, is consulted for every request, letting callers
+	// plug in test fixtures or forward the request to another upstream
+	// server. When it returns a non-nil Response, that Response's Leap,
+	// Stratum, ReferenceID and ReferenceTime replace the Server's own
+	// values; its other fields are ignored, and the reply's OriginTime,
+	// ReceiveTime and TransmitTime are always set by Serve.
+	Handler func(req *Request) *Response
+}
+
+// ListenAndServe listens for UDP NTP requests on addr and answers them
+// until an error occurs.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	return s.Serve(pc)
+}
+
+// Serve reads 48-byte NTP requests from pc and answers each one in turn.
+// ReceiveTime is filled in immediately on packet arrival and TransmitTime
+// is stamped as late as possible before the reply is written. Serve
+// returns only when reading from pc returns an error, for example because
+// it was closed; a failure to write a reply to one client (e.g. a
+// transient ICMP port-unreachable, routine for UDP) is skipped so Serve
+// keeps answering every other client.
+func (s *Server) Serve(pc net.PacketConn) error {
+	refClock := s.ReferenceClock
+	if refClock == nil {
+		refClock = time.Now
+	}
+
+	buf := make([]byte, 48)
+	for {
+		n, raddr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		receiveTime := time.Now()
+		if n < 48 {
+			continue
+		}
+
+		var reqMsg msg
+		if err := binary.Read(bytes.NewReader(buf[:48]), binary.BigEndian, &reqMsg); err != nil {
+			continue
+		}
+
+		respMsg := s.buildResponse(&reqMsg, raddr, receiveTime, refClock)
+
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		if err := binary.Write(w, binary.BigEndian, respMsg); err != nil {
+			continue
+		}
+		w.Flush()
+
+		if _, err := pc.WriteTo(out.Bytes(), raddr); err != nil {
+			continue
+		}
+	}
+}
+
+// buildResponse constructs the reply to reqMsg, consulting s.Handler when
+// set and falling back to s.Stratum/s.RefID/s.ReferenceClock otherwise.
+func (s *Server) buildResponse(reqMsg *msg, raddr net.Addr, receiveTime time.Time, refClock func() time.Time) *msg {
+	respMsg := new(msg)
+	respMsg.LiVnMode = reqMsg.LiVnMode
+	respMsg.setMode(server)
+	respMsg.setLeap(LeapNotInSync)
+
+	respMsg.Stratum = s.Stratum
+	respMsg.ReferenceID = binary.BigEndian.Uint32(s.RefID[:])
+	respMsg.ReferenceTime = toNtpTime(refClock())
+
+	if s.Handler != nil {
+		req := &Request{Addr: raddr, TransmitTime: reqMsg.TransmitTime.Time()}
+		if resp := s.Handler(req); resp != nil {
+			respMsg.setLeap(resp.Leap)
+			respMsg.Stratum = resp.Stratum
+			respMsg.ReferenceID = resp.ReferenceID
+			respMsg.ReferenceTime = toNtpTime(resp.ReferenceTime)
+		}
+	}
+
+	respMsg.OriginTime = reqMsg.TransmitTime
+	respMsg.ReceiveTime = toNtpTime(receiveTime)
+	respMsg.TransmitTime = toNtpTime(time.Now())
+	return respMsg
+}
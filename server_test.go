@@ -0,0 +1,118 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestServeAnswersRequest drives Server against a real UDP socket end to
+// end: it sends a client-shaped request and checks that Serve answers it
+// with a server-mode response carrying the configured Stratum and the
+// client's TransmitTime as OriginTime.
+func TestServeAnswersRequest(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	s := &Server{Stratum: 2, RefID: [4]byte{'L', 'O', 'C', 'L'}}
+	go s.Serve(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := new(msg)
+	req.setMode(client)
+	req.setVersion(4)
+	req.TransmitTime = toNtpTime(time.Now())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, req)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	respBuf := make([]byte, 48)
+	n, err := conn.Read(respBuf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n < 48 {
+		t.Fatalf("got %d byte response, want 48", n)
+	}
+
+	var respMsg msg
+	if err := binary.Read(bytes.NewReader(respBuf[:48]), binary.BigEndian, &respMsg); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if respMsg.getMode() != server {
+		t.Fatalf("response mode = %v, want server", respMsg.getMode())
+	}
+	if respMsg.Stratum != 2 {
+		t.Fatalf("response Stratum = %d, want 2", respMsg.Stratum)
+	}
+	if respMsg.OriginTime != req.TransmitTime {
+		t.Fatalf("response OriginTime = %v, want client's TransmitTime %v", respMsg.OriginTime, req.TransmitTime)
+	}
+}
+
+// TestServeSurvivesWriteError makes sure a write failure for one client
+// doesn't tear down the whole Serve loop: after a closed connection
+// causes a write error on its reply, Serve must still answer a second,
+// healthy client.
+func TestServeSurvivesWriteError(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	s := &Server{Stratum: 2, RefID: [4]byte{'L', 'O', 'C', 'L'}}
+	go s.Serve(pc)
+
+	bad, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	badReq := new(msg)
+	badReq.setMode(client)
+	badReq.TransmitTime = toNtpTime(time.Now())
+	var badBuf bytes.Buffer
+	binary.Write(&badBuf, binary.BigEndian, badReq)
+	if _, err := bad.Write(badBuf.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// Close the client immediately so the server's reply can provoke a
+	// write error (e.g. ICMP port-unreachable on some platforms); Serve
+	// must not let that stop it from answering the next client either
+	// way.
+	bad.Close()
+
+	good, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer good.Close()
+	goodReq := new(msg)
+	goodReq.setMode(client)
+	goodReq.TransmitTime = toNtpTime(time.Now())
+	var goodBuf bytes.Buffer
+	binary.Write(&goodBuf, binary.BigEndian, goodReq)
+	if _, err := good.Write(goodBuf.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	good.SetReadDeadline(time.Now().Add(2 * time.Second))
+	respBuf := make([]byte, 48)
+	if _, err := good.Read(respBuf); err != nil {
+		t.Fatalf("Serve stopped answering clients after an unrelated write error: %v", err)
+	}
+}
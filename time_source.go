@@ -0,0 +1,174 @@
+package ntp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFastNTPSyncPeriod is the resync interval a TimeSource uses
+	// before its first successful query has completed.
+	DefaultFastNTPSyncPeriod = 2 * time.Minute
+	// DefaultSlowNTPSyncPeriod is the resync interval a TimeSource uses
+	// once it has synchronized successfully at least once.
+	DefaultSlowNTPSyncPeriod = time.Hour
+)
+
+// TimeSourceOptions configures a TimeSource.
+type TimeSourceOptions struct {
+	// Handlers are queried together on every resync, via QueryAggregate.
+	Handlers []Handler
+	// MaxAllowedFailures is passed through to QueryAggregate.
+	MaxAllowedFailures int
+	// Strategy is passed through to QueryAggregate.
+	Strategy AggregationStrategy
+	// FastNTPSyncPeriod is the resync interval used before the first
+	// successful sync. Defaults to DefaultFastNTPSyncPeriod.
+	FastNTPSyncPeriod time.Duration
+	// SlowNTPSyncPeriod is the resync interval used after the first
+	// successful sync. Defaults to DefaultSlowNTPSyncPeriod.
+	SlowNTPSyncPeriod time.Duration
+}
+
+// TimeSource maintains a rolling clock offset by periodically re-querying
+// a set of NTP servers in the background. Now and Offset never block on
+// the network; they report the most recently observed offset. A
+// TimeSource starts out in "fast" mode, resyncing every
+// FastNTPSyncPeriod, and is promoted to "slow" mode, resyncing every
+// SlowNTPSyncPeriod, by its first successful sync. Any failed resync
+// demotes it back to fast mode.
+type TimeSource struct {
+	opt TimeSourceOptions
+
+	mu     sync.RWMutex
+	offset time.Duration
+	synced bool
+
+	subsMu sync.Mutex
+	subs   []chan time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTimeSource creates a TimeSource from opt, applying the default fast
+// and slow sync periods when left unset.
+func NewTimeSource(opt TimeSourceOptions) *TimeSource {
+	if opt.FastNTPSyncPeriod == 0 {
+		opt.FastNTPSyncPeriod = DefaultFastNTPSyncPeriod
+	}
+	if opt.SlowNTPSyncPeriod == 0 {
+		opt.SlowNTPSyncPeriod = DefaultSlowNTPSyncPeriod
+	}
+	return &TimeSource{opt: opt}
+}
+
+// Start begins periodic background resync. It returns immediately; the
+// resync loop runs until ctx is canceled or Stop is called. Calling Start
+// again before Stop returns is a programming error: it panics rather
+// than silently leaking the previous run's goroutine.
+func (t *TimeSource) Start(ctx context.Context) {
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.mu.Unlock()
+		panic("ntp: TimeSource.Start called while already running")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancel = cancel
+	done := make(chan struct{})
+	t.done = done
+	t.mu.Unlock()
+
+	go t.run(ctx, done)
+}
+
+// Stop terminates the background resync loop started by Start, and waits
+// for it to exit. It is a no-op if Start was never called.
+func (t *TimeSource) Stop() {
+	t.mu.Lock()
+	cancel := t.cancel
+	done := t.done
+	t.cancel = nil
+	t.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Now returns the current time, corrected by the most recently observed
+// clock offset. It never blocks on the network.
+func (t *TimeSource) Now() time.Time {
+	return time.Now().Add(t.Offset())
+}
+
+// Offset returns the most recently observed clock offset. It is zero
+// until the first successful resync completes.
+func (t *TimeSource) Offset() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.offset
+}
+
+// Subscribe returns a channel that receives each new offset as it is
+// observed by a successful resync. The channel is buffered by one and is
+// never closed by TimeSource. Sends never block: an offset is dropped for
+// a subscriber whose buffer still holds an unread value.
+func (t *TimeSource) Subscribe() <-chan time.Duration {
+	ch := make(chan time.Duration, 1)
+	t.subsMu.Lock()
+	t.subs = append(t.subs, ch)
+	t.subsMu.Unlock()
+	return ch
+}
+
+func (t *TimeSource) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		t.resync()
+
+		t.mu.RLock()
+		synced := t.synced
+		t.mu.RUnlock()
+		period := t.opt.FastNTPSyncPeriod
+		if synced {
+			period = t.opt.SlowNTPSyncPeriod
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(period):
+		}
+	}
+}
+
+func (t *TimeSource) resync() {
+	resp, _, err := QueryAggregate(t.opt.Handlers, t.opt.MaxAllowedFailures, t.opt.Strategy)
+
+	t.mu.Lock()
+	if err != nil {
+		t.synced = false
+		t.mu.Unlock()
+		return
+	}
+	t.offset = resp.ClockOffset
+	t.synced = true
+	t.mu.Unlock()
+
+	t.notify(resp.ClockOffset)
+}
+
+func (t *TimeSource) notify(offset time.Duration) {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	for _, ch := range t.subs {
+		select {
+		case ch <- offset:
+		default:
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package ntp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTimeSourceStartTwicePanics(t *testing.T) {
+	ts := NewTimeSource(TimeSourceOptions{})
+	ts.Start(context.Background())
+	defer ts.Stop()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Start to panic when already running")
+		}
+	}()
+	ts.Start(context.Background())
+}
+
+func TestTimeSourceStopWithoutStart(t *testing.T) {
+	ts := NewTimeSource(TimeSourceOptions{})
+	ts.Stop() // must not block or panic
+}